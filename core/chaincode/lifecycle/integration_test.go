@@ -191,4 +191,4 @@ var _ = Describe("Integration", func() {
 		})
 	})
 
-})
\ No newline at end of file
+})
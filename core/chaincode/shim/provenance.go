@@ -0,0 +1,36 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package shim
+
+import (
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+)
+
+// ProvenanceRecorder is implemented by the transaction simulator backing a chaincode's
+// invocation. It lets the shim declare lineage dependencies for a write directly on the
+// transaction's rwset instead of emitting a sibling "<key>_prov" write, which is the
+// forgeable, pre-ProvenanceRwSet convention validation now rejects outright once a
+// namespace's rwset carries a ProvenanceRwSet (see extractProvenanceDeps /
+// rejectReservedProvenanceSuffix in the validation package).
+type ProvenanceRecorder interface {
+	RecordProvenance(key string, depKeys []string, snapshotBlk uint64) error
+}
+
+// recordProvenanceEntry folds a ProvenanceEntry directly onto kvRwSet's own
+// MetadataWrites via rwsetutil.EncodeProvenanceEntry -- the one place a namespace's
+// in-flight kvrwset.KVRWSet should ever be populated with provenance during simulation.
+// Because MetadataWrites is a real field of kvrwset.KVRWSet, not a side channel, the entry
+// is still there once this transaction's rwset is marshaled and later deserialized on a
+// validating peer.
+func recordProvenanceEntry(kvRwSet *kvrwset.KVRWSet, key string, depKeys []string, snapshotBlk uint64) {
+	rwsetutil.EncodeProvenanceEntry(kvRwSet, &rwsetutil.ProvenanceEntry{
+		Key:         key,
+		DepKeys:     depKeys,
+		SnapshotBlk: snapshotBlk,
+	})
+}
@@ -0,0 +1,35 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package shim
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRecordProvenanceEntryRoundTripsThroughKvRwSet asserts recordProvenanceEntry folds its
+// ProvenanceEntry onto kvRwSet the same way rwsetutil.EncodeProvenanceEntry promises --
+// reassembled by rwsetutil.DecodeProvenanceRwSet exactly as a validating peer would after
+// this rwset crosses the endorser->orderer->committer boundary.
+func TestRecordProvenanceEntryRoundTripsThroughKvRwSet(t *testing.T) {
+	kvRwSet := &kvrwset.KVRWSet{
+		Writes: []*kvrwset.KVWrite{{Key: "w1", Value: []byte("v1")}},
+	}
+
+	recordProvenanceEntry(kvRwSet, "w1", []string{"a", "b"}, 42)
+
+	provenanceRwSet := rwsetutil.DecodeProvenanceRwSet(kvRwSet)
+	require.NotNil(t, provenanceRwSet)
+	require.Len(t, provenanceRwSet.Entries, 1)
+	assert.Equal(t, "w1", provenanceRwSet.Entries[0].Key)
+	assert.Equal(t, []string{"a", "b"}, provenanceRwSet.Entries[0].DepKeys)
+	assert.Equal(t, uint64(42), provenanceRwSet.Entries[0].SnapshotBlk)
+}
@@ -0,0 +1,26 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package privacyenabledstate
+
+import "github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+
+// HashedDB is the slice of the underlying state database that resolves hashed private-data
+// collection reads: the committed state itself is keyed by key hash, not by the plaintext
+// key, which is why it can't simply reuse statedb.VersionedDB's public-state methods.
+type HashedDB interface {
+	GetValueHash(namespace, collection string, keyHash []byte) (*statedb.VersionedValue, error)
+	GetPrivateDataMetadataByHash(namespace, collection string, keyHash []byte) ([]byte, error)
+	GetValueHashMultipleKeys(namespace, collection string, keyHashes [][]byte) ([]*statedb.VersionedValue, error)
+	GetPrivateDataMetadataByHashMultipleKeys(namespace, collection string, keyHashes [][]byte) ([][]byte, error)
+}
+
+// DB is the privacy-aware view of a channel's committed state: public reads are served by
+// the embedded statedb.VersionedDB, hashed private-data collection reads by HashedDB.
+type DB struct {
+	statedb.VersionedDB
+	HashedDB
+}
@@ -0,0 +1,173 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package rwsetutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+)
+
+// TxRwSet wraps a transaction's simulation results: one read-write set per namespace
+// (chaincode) the transaction touched.
+type TxRwSet struct {
+	NsRwSets []*NsRwSet
+}
+
+// NsRwSet wraps the read-write set for a single namespace. KvRwSet is the raw
+// kvrwset.KVRWSet that actually gets marshaled into the transaction's proposal response and
+// committed block data -- anything that needs to survive the endorser->orderer->committer
+// boundary, including lineage provenance (see EncodeProvenanceEntry/DecodeProvenanceRwSet
+// below), has to live inside it rather than as a sibling Go field that vanishes the moment a
+// real transaction's rwset is deserialized.
+type NsRwSet struct {
+	NameSpace        string
+	KvRwSet          *kvrwset.KVRWSet
+	CollHashedRwSets []*CollHashedRwSet
+}
+
+// CollHashedRwSet wraps the hashed read-write set for a single private data collection.
+type CollHashedRwSet struct {
+	CollectionName string
+	HashedRwSet    *kvrwset.HashedRWSet
+	PvtRwSetHash   []byte
+}
+
+// provenanceMetadataEntryName is the reserved kvrwset.KVMetadataEntry.Name a write's
+// lineage dependency record travels under once EncodeProvenanceEntry folds it onto that
+// write's own KVMetadataWrite. Piggybacking on KVMetadataWrite -- a real field of
+// kvrwset.KVRWSet that is genuinely marshaled into the committed transaction -- is what
+// lets a ProvenanceEntry survive (de)serialization; a plain Go-only field on NsRwSet never
+// would have. It replaces the historical "<key>_prov" sibling-write convention, which was
+// forgeable precisely because it had to masquerade as an ordinary write to get any wire
+// representation at all.
+const provenanceMetadataEntryName = "_fabricsharp_lineage_deps"
+
+// ProvenanceEntry records the lineage dependencies declared for a single write within a
+// namespace.
+type ProvenanceEntry struct {
+	// Key is the write key this entry declares dependencies for.
+	Key string
+	// DepKeys are the keys, within the same namespace, that Key's write is derived from.
+	DepKeys []string
+	// SnapshotBlk is the block number of the snapshot the endorsing chaincode observed when
+	// it declared these dependencies, as recorded at simulation time. Validation derives its
+	// own per-write OCC dependency snapshot independently from this transaction's own read
+	// set (see depSnapshotForWrite in the validation package); SnapshotBlk is carried for
+	// audit/debugging parity with what the chaincode believed its snapshot to be, not
+	// consumed by validation itself.
+	SnapshotBlk uint64
+}
+
+// ProvenanceRwSet collects the ProvenanceEntry records a namespace's rwset declared, as
+// reassembled by DecodeProvenanceRwSet from kvRwSet.MetadataWrites.
+type ProvenanceRwSet struct {
+	Entries []*ProvenanceEntry
+}
+
+// EncodeProvenanceEntry folds entry onto kvRwSet's own MetadataWrites as a reserved-name
+// KVMetadataEntry attached to entry.Key's metadata write, creating that metadata write if
+// the key doesn't already have one. This is the one place a write's lineage dependencies
+// should ever be attached during simulation -- see shim.ProvenanceRecorder -- since it
+// writes directly into the kvrwset.KVRWSet that gets marshaled for real, rather than into a
+// side channel that a validating peer would never see.
+func EncodeProvenanceEntry(kvRwSet *kvrwset.KVRWSet, entry *ProvenanceEntry) {
+	encoded := &kvrwset.KVMetadataEntry{
+		Name:  provenanceMetadataEntryName,
+		Value: serializeProvenanceValue(entry.DepKeys, entry.SnapshotBlk),
+	}
+	for _, mw := range kvRwSet.MetadataWrites {
+		if mw.Key == entry.Key {
+			mw.Entries = append(mw.Entries, encoded)
+			return
+		}
+	}
+	kvRwSet.MetadataWrites = append(kvRwSet.MetadataWrites, &kvrwset.KVMetadataWrite{
+		Key:     entry.Key,
+		Entries: []*kvrwset.KVMetadataEntry{encoded},
+	})
+}
+
+// DecodeProvenanceRwSet scans kvRwSet's MetadataWrites for reserved-name provenance
+// entries and reassembles the ProvenanceRwSet that EncodeProvenanceEntry attached during
+// simulation. It returns nil when kvRwSet carries none -- the namespace's rwset predates
+// ProvenanceRwSet, or never declared any dependencies -- so callers can fall back to the
+// legacy "_prov" write convention exactly as they would for a nil sibling field.
+func DecodeProvenanceRwSet(kvRwSet *kvrwset.KVRWSet) *ProvenanceRwSet {
+	var provenanceRwSet *ProvenanceRwSet
+	for _, mw := range kvRwSet.MetadataWrites {
+		for _, entry := range mw.Entries {
+			if entry.Name != provenanceMetadataEntryName {
+				continue
+			}
+			depKeys, snapshotBlk := deserializeProvenanceValue(entry.Value)
+			if provenanceRwSet == nil {
+				provenanceRwSet = &ProvenanceRwSet{}
+			}
+			provenanceRwSet.Entries = append(provenanceRwSet.Entries, &ProvenanceEntry{
+				Key:         mw.Key,
+				DepKeys:     depKeys,
+				SnapshotBlk: snapshotBlk,
+			})
+		}
+	}
+	return provenanceRwSet
+}
+
+// StripProvenanceMetadataEntries returns a copy of metadataWrite with any reserved-name
+// provenance entry (see EncodeProvenanceEntry) removed, plus whether anything was removed.
+// DecodeProvenanceRwSet reads those entries back out of KvRwSet.MetadataWrites so they can
+// ride the real wire format, but that is the *only* consumer they should ever reach --
+// committing them onto the key's real statedb metadata via statemetadata.Serialize would
+// reopen the exact hole ProvenanceRwSet exists to close, just moved from a write-key suffix
+// to a metadata-entry name. Callers that apply a namespace's MetadataWrites to committed
+// state (see applyTxRwset in the validation package) must run every KVMetadataWrite through
+// this first. When stripping empties out a write that originally carried real entries (as
+// opposed to one that was already nil, i.e. a metadata delete), the returned entries slice
+// is empty rather than nil so the caller can tell "nothing left to commit" apart from "this
+// write deletes the key's metadata".
+func StripProvenanceMetadataEntries(metadataWrite *kvrwset.KVMetadataWrite) (stripped *kvrwset.KVMetadataWrite, removed bool) {
+	if metadataWrite == nil || metadataWrite.Entries == nil {
+		return metadataWrite, false
+	}
+	kept := make([]*kvrwset.KVMetadataEntry, 0, len(metadataWrite.Entries))
+	for _, entry := range metadataWrite.Entries {
+		if entry.Name == provenanceMetadataEntryName {
+			removed = true
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if !removed {
+		return metadataWrite, false
+	}
+	return &kvrwset.KVMetadataWrite{Key: metadataWrite.Key, Entries: kept}, true
+}
+
+// serializeProvenanceValue packs depKeys/snapshotBlk into a KVMetadataEntry.Value in the
+// same "dep1_dep2_..." shape the legacy "_prov" convention used for its dep list, prefixed
+// with the snapshot block number, so a real peer's committed block data carries exactly the
+// bytes DecodeProvenanceRwSet needs to reconstruct the entry.
+func serializeProvenanceValue(depKeys []string, snapshotBlk uint64) []byte {
+	return []byte(fmt.Sprintf("%d|%s", snapshotBlk, strings.Join(depKeys, "_")))
+}
+
+func deserializeProvenanceValue(value []byte) (depKeys []string, snapshotBlk uint64) {
+	parts := strings.SplitN(string(value), "|", 2)
+	snapshotBlk, _ = strconv.ParseUint(parts[0], 10, 64)
+	if len(parts) < 2 {
+		return nil, snapshotBlk
+	}
+	for _, dk := range strings.Split(parts[1], "_") {
+		if dk != "" {
+			depKeys = append(depKeys, dk)
+		}
+	}
+	return depKeys, snapshotBlk
+}
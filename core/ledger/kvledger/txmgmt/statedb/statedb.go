@@ -0,0 +1,29 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statedb
+
+// VersionedValue holds the value and associated metadata for a key as last committed to a
+// namespace's state. A non-nil *VersionedValue whose Value is nil means the key exists but
+// was last written with a nil value -- distinct from the key not existing at all (a nil
+// *VersionedValue) and from a key last written with an empty, non-nil []byte{} value. See
+// FAB-18386.
+type VersionedValue struct {
+	Value    []byte
+	Metadata []byte
+}
+
+// VersionedDB is the slice of the underlying state database that the validation package's
+// public-state lookups need: GetState/GetStateMetadata for the single-transaction path, and
+// the MultipleKeys variants for prepareBlockOps's block-level bulk preload (see FAB-11328 --
+// pulling from state one key at a time, especially against CouchDB, pays a significant
+// performance penalty).
+type VersionedDB interface {
+	GetState(namespace, key string) (*VersionedValue, error)
+	GetStateMetadata(namespace, key string) ([]byte, error)
+	GetStateMultipleKeys(namespace string, keys []string) ([]*VersionedValue, error)
+	GetStateMetadataMultipleKeys(namespace string, keys []string) ([][]byte, error)
+}
@@ -7,6 +7,7 @@ SPDX-License-Identifier: Apache-2.0
 package validation
 
 import (
+	"fmt"
 	"math"
 	"strings"
 
@@ -19,11 +20,68 @@ import (
 	"github.com/hyperledger/fabric/orderer/common/localconfig"
 )
 
+// legacyProvKeySuffix is the reserved key suffix that was historically overloaded to
+// smuggle lineage dependency metadata through ordinary user writes. It is still accepted
+// on read when localconfig.LegacyProvenanceFormat() is enabled, purely for upgrade
+// compatibility with ledgers written before ProvenanceRwSet existed.
+const legacyProvKeySuffix = "_prov"
+
+// prepareTxOps prepares a single transaction's txOps. It is a thin wrapper around
+// prepareBlockOps for the (common outside of block-level replay) case of a single
+// transaction, so that the one-key-at-a-time statedb lookups the bulk preload in
+// prepareBlockOps/preloadBlockOps exists to avoid are never reintroduced through a second,
+// divergent code path.
 func prepareTxOps(rwset *rwsetutil.TxRwSet, txht *version.Height,
 	precedingUpdates *publicAndHashUpdates, db *privacyenabledstate.DB) (txOps, error) {
-	txops := txOps{}
-	txops.applyTxRwset(rwset)
-	//logger.Debugf("prepareTxOps() txops after applying raw rwset=%#v", spew.Sdump(txops))
+	allTxOps, err := prepareBlockOps([]*rwsetutil.TxRwSet{rwset}, precedingUpdates, db, nil)
+	if err != nil {
+		return nil, err
+	}
+	return allTxOps[0], nil
+}
+
+// prepareBlockOps builds the txOps for every transaction in a block. Rather than resolving
+// each transaction's missing value/metadata one key at a time against statedb (see the
+// FAB-11328 TODO that used to sit on retrieveLatestState/retrieveLatestMetadata), it builds
+// every transaction's txOps first, collects the full set of (ns, coll, key) lookups the
+// block as a whole will need, and resolves them with one bulk call per namespace/collection
+// before any per-tx merge happens.
+//
+// extra lets a caller that already bulk-preloads a different (ns, coll, key) keyspace over
+// the same block fold its own keys into this same bulk call instead of running a second,
+// overlapping one -- e.g. VSCC's endorsement-policy resolution, which the FAB-11328 TODO
+// this preload replaces already called out as needing to share the same bulkload. Pass nil
+// to preload only the keys this block's own transactions touch, exactly as before.
+func prepareBlockOps(rwsets []*rwsetutil.TxRwSet,
+	precedingUpdates *publicAndHashUpdates, db *privacyenabledstate.DB, extra *PreloadKeys) ([]txOps, error) {
+	allTxOps := make([]txOps, len(rwsets))
+	for i, rwset := range rwsets {
+		txops := txOps{}
+		if err := txops.applyTxRwset(rwset); err != nil {
+			return nil, err
+		}
+		allTxOps[i] = txops
+	}
+
+	cache, err := preloadBlockOps(allTxOps, db, extra)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, txops := range allTxOps {
+		if err := finalizeTxOps(txops, precedingUpdates, db, cache); err != nil {
+			return nil, err
+		}
+	}
+	return allTxOps, nil
+}
+
+// finalizeTxOps merges in, for every key in txops that isn't already fully determined by
+// the transaction's own writes, the latest committed value or metadata -- consulting cache
+// first when one was preloaded for the whole block, and falling through to db only on a
+// cache miss (or when cache is nil, i.e. the single-transaction prepareTxOps path).
+func finalizeTxOps(txops txOps, precedingUpdates *publicAndHashUpdates, db *privacyenabledstate.DB, cache *blockOpsCache) error {
+	//logger.Debugf("finalizeTxOps() txops before merge=%#v", spew.Sdump(txops))
 	for ck, keyop := range txops {
 		// check if the final state of the key, value and metadata, is already present in the transaction, then skip
 		// otherwise we need to retrieve latest state and merge in the current value or metadata update
@@ -33,9 +91,9 @@ func prepareTxOps(rwset *rwsetutil.TxRwSet, txht *version.Height,
 
 		// check if only value is updated in the current transaction then merge the metadata from last committed state
 		if keyop.isOnlyUpsert() {
-			latestMetadata, err := retrieveLatestMetadata(ck.ns, ck.coll, ck.key, precedingUpdates, db)
+			latestMetadata, err := retrieveLatestMetadata(ck.ns, ck.coll, ck.key, precedingUpdates, db, cache)
 			if err != nil {
-				return nil, err
+				return err
 			}
 			keyop.metadata = latestMetadata
 			continue
@@ -43,9 +101,9 @@ func prepareTxOps(rwset *rwsetutil.TxRwSet, txht *version.Height,
 
 		// only metadata is updated in the current transaction. Merge the value from the last committed state
 		// If the key does not exist in the last state, make this key as noop in current transaction
-		latestVal, err := retrieveLatestState(ck.ns, ck.coll, ck.key, precedingUpdates, db)
+		latestVal, err := retrieveLatestState(ck.ns, ck.coll, ck.key, precedingUpdates, db, cache)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if latestVal != nil {
 			keyop.value = latestVal.Value
@@ -53,8 +111,8 @@ func prepareTxOps(rwset *rwsetutil.TxRwSet, txht *version.Height,
 			delete(txops, ck)
 		}
 	}
-	//logger.Debugf("prepareTxOps() txops after final processing=%#v", spew.Sdump(txops))
-	return txops, nil
+	//logger.Debugf("finalizeTxOps() txops after merge=%#v", spew.Sdump(txops))
+	return nil
 }
 
 // applyTxRwset records the upsertion/deletion of a kv and updatation/deletion
@@ -63,8 +121,15 @@ func (txops txOps) applyTxRwset(rwset *rwsetutil.TxRwSet) error {
 	for _, nsRWSet := range rwset.NsRwSets {
 		ns := nsRWSet.NameSpace
 		if localconfig.LineageSupported() {
-			// By default, MaxUint64 implies no snapshot read is used
-			var depSnapshot uint64 = math.MaxUint64
+			// readBlockNums records, per read key, the block number at which that key's
+			// version was read (MaxUint64 when the key had no prior version, i.e. no
+			// snapshot constraint). Only populated -- and only consulted below -- under
+			// OCC, where a write's dependency snapshot must be derived from the reads of
+			// the specific keys it depends on, not from an arbitrary namespace-wide read.
+			var readBlockNums map[string]uint64
+			if localconfig.IsOCC() {
+				readBlockNums = make(map[string]uint64, len(nsRWSet.KvRwSet.Reads))
+			}
 			for _, kvRead := range nsRWSet.KvRwSet.Reads {
 				if kvRead.Version != nil {
 					logger.Infof("Ns: %s, Read Key: %s, Read Version Blk Num: %d", ns, kvRead.GetKey(), kvRead.Version.BlockNum)
@@ -72,41 +137,29 @@ func (txops txOps) applyTxRwset(rwset *rwsetutil.TxRwSet) error {
 					logger.Infof("Ns: %s, Read Key: %s, Read Version Blk Num: nil", ns, kvRead.GetKey())
 				}
 				if localconfig.IsOCC() {
-					// Under OCC, the version of each read key is the snapshot
 					if kvRead.Version == nil {
-						depSnapshot = math.MaxUint64
+						readBlockNums[kvRead.GetKey()] = math.MaxUint64
 					} else {
-						depSnapshot = kvRead.Version.BlockNum
+						readBlockNums[kvRead.GetKey()] = kvRead.Version.BlockNum
 					}
 				}
 			}
-			// }
 
-			deps := map[string][]string{}
-			for _, kvWrite := range nsRWSet.KvRwSet.Writes {
-				if !strings.HasSuffix(kvWrite.Key, "_prov") {
-					continue
-				}
-				// record with key XX_prov captures the dependency of XX, in the format of YY_ZZ_.
-				// Need to ignore the whitespace after splitting with "_"
-				key := strings.Split(kvWrite.Key, "_")[0]
-				depKeys := []string{}
-				for _, dk := range strings.Split(string(kvWrite.Value), "_") {
-					if dk != "" {
-						depKeys = append(depKeys, dk)
-					}
-				}
-				deps[key] = depKeys
+			deps, err := extractProvenanceDeps(ns, nsRWSet)
+			if err != nil {
+				return err
 			}
 			logger.Infof("Preprocess Txn Deps: [%v], length: %d", deps, len(deps))
+			provenanceRwSet := rwsetutil.DecodeProvenanceRwSet(nsRWSet.KvRwSet)
 			for _, kvWrite := range nsRWSet.KvRwSet.Writes {
-				if strings.HasSuffix(kvWrite.Key, "_prov") {
+				if provenanceRwSet == nil && strings.HasSuffix(kvWrite.Key, legacyProvKeySuffix) && localconfig.LegacyProvenanceFormat() {
 					continue
 				}
 				keyDeps := []string{}
 				if d, ok := deps[kvWrite.Key]; ok {
 					keyDeps = d
 				}
+				depSnapshot := depSnapshotForWrite(keyDeps, readBlockNums)
 				txops.applyKVWriteWithDep(ns, "", kvWrite, keyDeps, depSnapshot)
 			}
 
@@ -118,7 +171,21 @@ func (txops txOps) applyTxRwset(rwset *rwsetutil.TxRwSet) error {
 		}
 
 		for _, kvMetadataWrite := range nsRWSet.KvRwSet.MetadataWrites {
-			txops.applyMetadata(ns, "", kvMetadataWrite)
+			// A write's lineage ProvenanceEntry (see rwsetutil.EncodeProvenanceEntry) rides
+			// along on this same KVMetadataWrite so it gets a real wire representation, but
+			// it must never reach the key's committed statedb metadata -- strip it before
+			// applying, same as a forged reserved-name entry would need to be.
+			metadataWrite, provenanceStripped := rwsetutil.StripProvenanceMetadataEntries(kvMetadataWrite)
+			if provenanceStripped && len(metadataWrite.Entries) == 0 {
+				// Nothing left to commit -- this metadata write carried only a provenance
+				// entry, not a real metadata update, so applying it would either wipe the
+				// key's existing metadata (Entries == nil means delete) or commit an empty
+				// metadata blob. Skip it outright.
+				continue
+			}
+			if err := txops.applyMetadata(ns, "", metadataWrite); err != nil {
+				return err
+			}
 		}
 
 		// apply collection level kvwrite and kvMetadataWrite
@@ -147,6 +214,106 @@ func (txops txOps) applyTxRwset(rwset *rwsetutil.TxRwSet) error {
 	return nil
 }
 
+// extractProvenanceDeps resolves, for a namespace's read-write set, the map of
+// write-key -> dependency-keys that the lineage-aware validation path needs.
+//
+// The preferred source is the structured ProvenanceRwSet that rwsetutil.DecodeProvenanceRwSet
+// reassembles from kvRwSet.MetadataWrites (see rwsetutil.EncodeProvenanceEntry): it is never
+// committed as a regular write and so can't collide with, or be forged via, user state -- and
+// once a namespace's rwset carries one, the reserved "_prov" suffix is rejected on every
+// ordinary write regardless of localconfig.LegacyProvenanceFormat(), since the new format
+// being in use means "_prov" can no longer mean anything but an attempted forgery. When a
+// namespace's rwset predates that extension (no ProvenanceRwSet present) and
+// localconfig.LegacyProvenanceFormat() is enabled, we fall back to parsing the old
+// "<key>_prov" -> "dep1_dep2_..." convention for upgrade compatibility. Once the legacy flag
+// is off too, any write landing on the reserved "_prov" suffix is rejected rather than
+// silently reinterpreted.
+func extractProvenanceDeps(ns string, nsRWSet *rwsetutil.NsRwSet) (map[string][]string, error) {
+	deps := map[string][]string{}
+	kvRwSet := nsRWSet.KvRwSet
+
+	if provenanceRwSet := rwsetutil.DecodeProvenanceRwSet(kvRwSet); provenanceRwSet != nil {
+		if err := rejectReservedProvenanceSuffix(ns, kvRwSet.Writes); err != nil {
+			return nil, err
+		}
+		for _, entry := range provenanceRwSet.Entries {
+			deps[entry.Key] = append([]string{}, entry.DepKeys...)
+		}
+		return deps, nil
+	}
+
+	if localconfig.LegacyProvenanceFormat() {
+		for _, kvWrite := range kvRwSet.Writes {
+			if !strings.HasSuffix(kvWrite.Key, legacyProvKeySuffix) {
+				continue
+			}
+			// record with key XX_prov captures the dependency of XX, in the format of YY_ZZ_.
+			// Need to ignore the whitespace after splitting with "_"
+			key := strings.Split(kvWrite.Key, "_")[0]
+			depKeys := []string{}
+			// A nil Value (no dep payload at all) is not the same thing as an explicit
+			// empty-string dep list; guard it explicitly rather than let
+			// strings.Split(string(nil), "_") silently produce [""].
+			if kvWrite.Value != nil {
+				for _, dk := range strings.Split(string(kvWrite.Value), "_") {
+					if dk != "" {
+						depKeys = append(depKeys, dk)
+					}
+				}
+			}
+			deps[key] = depKeys
+		}
+		return deps, nil
+	}
+
+	if err := rejectReservedProvenanceSuffix(ns, kvRwSet.Writes); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// rejectReservedProvenanceSuffix rejects any write in writes landing on the reserved
+// "_prov" key suffix. It is consulted both when a namespace's rwset already carries a
+// ProvenanceRwSet (the new format is in effect, so "_prov" can only be a forged or stale
+// write) and, once legacy support is off, when no provenance is carried at all.
+func rejectReservedProvenanceSuffix(ns string, writes []*kvrwset.KVWrite) error {
+	for _, kvWrite := range writes {
+		if strings.HasSuffix(kvWrite.Key, legacyProvKeySuffix) {
+			return fmt.Errorf("ns %s: write to key %s uses the reserved %q suffix; "+
+				"lineage dependencies must be carried in ProvenanceRwSet", ns, kvWrite.Key, legacyProvKeySuffix)
+		}
+	}
+	return nil
+}
+
+// depSnapshotForWrite computes the OCC dependency snapshot for a single write: the maximum
+// committed block number among the write's declared dep keys, as observed in this
+// transaction's own read set. This is the snapshot below which a concurrent writer to any
+// of those dep keys would invalidate the write at commit time. When readBlockNums is nil
+// (lineage is on but OCC is off), none of the dep keys were actually read in this
+// transaction, or every dep key that was read had no prior version (readBlockNums holds
+// math.MaxUint64 for it, i.e. the key didn't exist yet), math.MaxUint64 is returned, meaning
+// no snapshot constraint applies. A dep key with no prior version must never win a max()
+// against a dep key that really was read at some committed block -- MaxUint64 is the
+// sentinel for "this one dep imposes no constraint," not "this is the write's binding
+// constraint," so it is excluded from the max rather than folded into it.
+func depSnapshotForWrite(depKeys []string, readBlockNums map[string]uint64) uint64 {
+	if readBlockNums == nil {
+		return math.MaxUint64
+	}
+	depSnapshot := uint64(math.MaxUint64)
+	for _, depKey := range depKeys {
+		blockNum, ok := readBlockNums[depKey]
+		if !ok || blockNum == math.MaxUint64 {
+			continue
+		}
+		if depSnapshot == math.MaxUint64 || blockNum > depSnapshot {
+			depSnapshot = blockNum
+		}
+	}
+	return depSnapshot
+}
+
 // applyKVWrite records upsertion/deletion of a kvwrite
 func (txops txOps) applyKVWrite(ns, coll string, kvWrite *kvrwset.KVWrite) {
 	if kvWrite.IsDelete {
@@ -178,46 +345,230 @@ func (txops txOps) applyMetadata(ns, coll string, metadataWrite *kvrwset.KVMetad
 	return nil
 }
 
-// retrieveLatestState returns the value of the key from the precedingUpdates (if the key was operated upon by a previous tran in the block).
-// If the key not present in the precedingUpdates, then this function, pulls the latest value from statedb
-// TODO FAB-11328, pulling from state for (especially for couchdb) will pay significant performance penalty so a bulkload would be helpful.
-// Further, all the keys that gets written will be required to pull from statedb by vscc for endorsement policy check (in the case of key level
-// endorsement) and hence, the bulkload should be combined
+// retrieveLatestState returns the value of the key from the precedingUpdates (if the key was operated upon by a previous tran in the block),
+// then from cache (if prepareBlockOps preloaded it for the block), and only then falls through to a single-key statedb lookup.
+// See FAB-11328: pulling from state one key at a time (especially for couchdb) pays a significant performance penalty, which is why
+// prepareBlockOps bulk-preloads the block's keyspace into cache up front; cache is nil on the single-transaction prepareTxOps path.
+//
+// The returned VersionedValue, when non-nil, may itself carry a nil Value (the key exists
+// but was last written with a nil value, as opposed to an empty []byte{} value) -- callers
+// must not conflate "the key is absent" (a nil *VersionedValue) with "the key's value is
+// nil" (a non-nil *VersionedValue whose Value field is nil). See FAB-18386.
 func retrieveLatestState(ns, coll, key string,
-	precedingUpdates *publicAndHashUpdates, db *privacyenabledstate.DB) (*statedb.VersionedValue, error) {
-	var vv *statedb.VersionedValue
-	var err error
+	precedingUpdates *publicAndHashUpdates, db *privacyenabledstate.DB, cache *blockOpsCache) (*statedb.VersionedValue, error) {
+	ck := compositeKey{ns, coll, key}
 	if coll == "" {
-		vv := precedingUpdates.publicUpdates.Get(ns, key)
-		if vv == nil {
-			vv, err = db.GetState(ns, key)
+		if vv := precedingUpdates.publicUpdates.Get(ns, key); vv != nil {
+			return vv, nil
+		}
+		if cache != nil {
+			if vv, ok := cache.values[ck]; ok {
+				return vv, nil
+			}
 		}
-		return vv, err
+		return db.GetState(ns, key)
 	}
 
-	vv = precedingUpdates.hashUpdates.Get(ns, coll, key)
-	if vv == nil {
-		vv, err = db.GetValueHash(ns, coll, []byte(key))
+	if vv := precedingUpdates.hashUpdates.Get(ns, coll, key); vv != nil {
+		return vv, nil
 	}
-	return vv, err
+	if cache != nil {
+		if vv, ok := cache.values[ck]; ok {
+			return vv, nil
+		}
+	}
+	return db.GetValueHash(ns, coll, []byte(key))
 }
 
 func retrieveLatestMetadata(ns, coll, key string,
-	precedingUpdates *publicAndHashUpdates, db *privacyenabledstate.DB) ([]byte, error) {
+	precedingUpdates *publicAndHashUpdates, db *privacyenabledstate.DB, cache *blockOpsCache) ([]byte, error) {
+	ck := compositeKey{ns, coll, key}
 	if coll == "" {
-		vv := precedingUpdates.publicUpdates.Get(ns, key)
-		if vv != nil {
+		if vv := precedingUpdates.publicUpdates.Get(ns, key); vv != nil {
 			return vv.Metadata, nil
 		}
+		if cache != nil {
+			if metadata, ok := cache.metadata[ck]; ok {
+				return metadata, nil
+			}
+		}
 		return db.GetStateMetadata(ns, key)
 	}
-	vv := precedingUpdates.hashUpdates.Get(ns, coll, key)
-	if vv != nil {
+	if vv := precedingUpdates.hashUpdates.Get(ns, coll, key); vv != nil {
 		return vv.Metadata, nil
 	}
+	if cache != nil {
+		if metadata, ok := cache.metadata[ck]; ok {
+			return metadata, nil
+		}
+	}
 	return db.GetPrivateDataMetadataByHash(ns, coll, []byte(key))
 }
 
+// blockOpsCache holds the result of a single bulk-preload pass over an entire block's
+// keyspace (see prepareBlockOps/preloadBlockOps), keyed the same way as txOps so that
+// retrieveLatestState/retrieveLatestMetadata can consult it before ever reaching db.
+type blockOpsCache struct {
+	values   map[compositeKey]*statedb.VersionedValue
+	metadata map[compositeKey][]byte
+}
+
+// NsColl identifies a namespace (and, for private data, a collection within it) whose
+// keyspace is resolved together in a single bulk call. It is exported so a caller combining
+// its own bulk lookup with this package's block-level preload (see PreloadKeys) can describe
+// its keys in the same shape.
+type NsColl struct {
+	Namespace  string
+	Collection string
+}
+
+// PreloadKeys supplements the (ns, coll) -> key-set that prepareBlockOps/preloadBlockOps
+// collects from the block's own transactions with additional keys a caller wants resolved
+// in that same bulk pass -- most notably VSCC's endorsement-policy key lookups, which
+// resolve largely the same (ns, coll, key) keyspace this preload already visits for the
+// block. Either field may be nil.
+type PreloadKeys struct {
+	ValueKeys    map[NsColl]map[string]bool
+	MetadataKeys map[NsColl]map[string]bool
+}
+
+// collectBlockOpsKeys walks every transaction's txOps in the block and partitions the keys
+// that still need a latest-state lookup from the ones that only need a latest-metadata
+// lookup (mirroring the isOnlyUpsert/else branches in finalizeTxOps), grouped by namespace
+// and collection so each group can be resolved with one bulk call.
+func collectBlockOpsKeys(allTxOps []txOps) (valueKeys, metadataKeys map[NsColl]map[string]bool) {
+	valueKeys = map[NsColl]map[string]bool{}
+	metadataKeys = map[NsColl]map[string]bool{}
+	for _, txops := range allTxOps {
+		for ck, keyop := range txops {
+			if keyop.isDelete() || keyop.isUpsertAndMetadataUpdate() {
+				continue
+			}
+			nc := NsColl{ck.ns, ck.coll}
+			if keyop.isOnlyUpsert() {
+				addBlockOpsKey(metadataKeys, nc, ck.key)
+			} else {
+				addBlockOpsKey(valueKeys, nc, ck.key)
+			}
+		}
+	}
+	return valueKeys, metadataKeys
+}
+
+func addBlockOpsKey(set map[NsColl]map[string]bool, nc NsColl, key string) {
+	keys, ok := set[nc]
+	if !ok {
+		keys = map[string]bool{}
+		set[nc] = keys
+	}
+	keys[key] = true
+}
+
+// mergeBlockOpsKeys folds src's keys into dst in place, so a caller-supplied PreloadKeys
+// can be combined with the block's own keys before the bulk-fetch loop below ever runs.
+func mergeBlockOpsKeys(dst, src map[NsColl]map[string]bool) {
+	for nc, keys := range src {
+		for key := range keys {
+			addBlockOpsKey(dst, nc, key)
+		}
+	}
+}
+
+// preloadBlockOps resolves the block's full (ns, coll, key) keyspace -- plus whatever extra
+// supplies -- with one bulk call per namespace/collection instead of the one-key-at-a-time
+// db.GetState/GetStateMetadata/GetValueHash/GetPrivateDataMetadataByHash calls that
+// retrieveLatestState/retrieveLatestMetadata would otherwise make per transaction.
+func preloadBlockOps(allTxOps []txOps, db *privacyenabledstate.DB, extra *PreloadKeys) (*blockOpsCache, error) {
+	valueKeys, metadataKeys := collectBlockOpsKeys(allTxOps)
+	if extra != nil {
+		mergeBlockOpsKeys(valueKeys, extra.ValueKeys)
+		mergeBlockOpsKeys(metadataKeys, extra.MetadataKeys)
+	}
+
+	cache := &blockOpsCache{
+		values:   map[compositeKey]*statedb.VersionedValue{},
+		metadata: map[compositeKey][]byte{},
+	}
+
+	for nc, keys := range valueKeys {
+		values, err := bulkGetValues(db, nc.Namespace, nc.Collection, keys)
+		if err != nil {
+			return nil, err
+		}
+		for key, vv := range values {
+			cache.values[compositeKey{nc.Namespace, nc.Collection, key}] = vv
+		}
+	}
+
+	for nc, keys := range metadataKeys {
+		metadata, err := bulkGetMetadata(db, nc.Namespace, nc.Collection, keys)
+		if err != nil {
+			return nil, err
+		}
+		for key, m := range metadata {
+			cache.metadata[compositeKey{nc.Namespace, nc.Collection, key}] = m
+		}
+	}
+
+	return cache, nil
+}
+
+func bulkGetValues(db *privacyenabledstate.DB, ns, coll string, keys map[string]bool) (map[string]*statedb.VersionedValue, error) {
+	keyList := make([]string, 0, len(keys))
+	for key := range keys {
+		keyList = append(keyList, key)
+	}
+
+	var vals []*statedb.VersionedValue
+	var err error
+	if coll == "" {
+		vals, err = db.GetStateMultipleKeys(ns, keyList)
+	} else {
+		keyHashes := make([][]byte, len(keyList))
+		for i, key := range keyList {
+			keyHashes[i] = []byte(key)
+		}
+		vals, err = db.GetValueHashMultipleKeys(ns, coll, keyHashes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]*statedb.VersionedValue, len(keyList))
+	for i, key := range keyList {
+		results[key] = vals[i]
+	}
+	return results, nil
+}
+
+func bulkGetMetadata(db *privacyenabledstate.DB, ns, coll string, keys map[string]bool) (map[string][]byte, error) {
+	keyList := make([]string, 0, len(keys))
+	for key := range keys {
+		keyList = append(keyList, key)
+	}
+
+	var metadata [][]byte
+	var err error
+	if coll == "" {
+		metadata, err = db.GetStateMetadataMultipleKeys(ns, keyList)
+	} else {
+		keyHashes := make([][]byte, len(keyList))
+		for i, key := range keyList {
+			keyHashes[i] = []byte(key)
+		}
+		metadata, err = db.GetPrivateDataMetadataByHashMultipleKeys(ns, coll, keyHashes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string][]byte, len(keyList))
+	for i, key := range keyList {
+		results[key] = metadata[i]
+	}
+	return results, nil
+}
+
 type keyOpsFlag uint8
 
 const (
@@ -234,7 +585,11 @@ type compositeKey struct {
 type txOps map[compositeKey]*keyOps
 
 type keyOps struct {
-	flag        keyOpsFlag
+	flag keyOpsFlag
+	// value holds the pending write value for the key. A nil value (the key was written
+	// with kvWrite.Value == nil and IsDelete == false) must be preserved as nil all the way
+	// through to the committed VersionedValue -- it is distinct from a zero-length []byte{}
+	// value and from keyDelete (the flag, not the value, signals deletion; see isDelete).
 	value       []byte
 	metadata    []byte
 	deps        []string
@@ -305,4 +660,4 @@ func (keyops keyOps) isUpsertAndMetadataUpdate() bool {
 
 func (keyops keyOps) isOnlyUpsert() bool {
 	return keyops.flag|upsertVal == upsertVal
-}
\ No newline at end of file
+}
@@ -0,0 +1,490 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package validation
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/privacyenabledstate"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+	"github.com/hyperledger/fabric/orderer/common/localconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDB is a minimal in-memory privacyenabledstate.VersionedDB/HashedDB for exercising
+// finalizeTxOps/retrieveLatestState without a real statedb.
+type fakeDB struct {
+	public map[string]*statedb.VersionedValue
+	hashed map[string]*statedb.VersionedValue
+}
+
+func newFakeDB() *privacyenabledstate.DB {
+	f := &fakeDB{public: map[string]*statedb.VersionedValue{}, hashed: map[string]*statedb.VersionedValue{}}
+	return &privacyenabledstate.DB{VersionedDB: f, HashedDB: f}
+}
+
+func (f *fakeDB) GetState(ns, key string) (*statedb.VersionedValue, error) {
+	return f.public[ns+key], nil
+}
+func (f *fakeDB) GetStateMetadata(ns, key string) ([]byte, error) {
+	if vv := f.public[ns+key]; vv != nil {
+		return vv.Metadata, nil
+	}
+	return nil, nil
+}
+func (f *fakeDB) GetStateMultipleKeys(ns string, keys []string) ([]*statedb.VersionedValue, error) {
+	vals := make([]*statedb.VersionedValue, len(keys))
+	for i, key := range keys {
+		vals[i] = f.public[ns+key]
+	}
+	return vals, nil
+}
+func (f *fakeDB) GetStateMetadataMultipleKeys(ns string, keys []string) ([][]byte, error) {
+	mds := make([][]byte, len(keys))
+	for i, key := range keys {
+		if vv := f.public[ns+key]; vv != nil {
+			mds[i] = vv.Metadata
+		}
+	}
+	return mds, nil
+}
+func (f *fakeDB) GetValueHash(ns, coll string, keyHash []byte) (*statedb.VersionedValue, error) {
+	return f.hashed[ns+coll+string(keyHash)], nil
+}
+func (f *fakeDB) GetPrivateDataMetadataByHash(ns, coll string, keyHash []byte) ([]byte, error) {
+	if vv := f.hashed[ns+coll+string(keyHash)]; vv != nil {
+		return vv.Metadata, nil
+	}
+	return nil, nil
+}
+func (f *fakeDB) GetValueHashMultipleKeys(ns, coll string, keyHashes [][]byte) ([]*statedb.VersionedValue, error) {
+	vals := make([]*statedb.VersionedValue, len(keyHashes))
+	for i, kh := range keyHashes {
+		vals[i] = f.hashed[ns+coll+string(kh)]
+	}
+	return vals, nil
+}
+func (f *fakeDB) GetPrivateDataMetadataByHashMultipleKeys(ns, coll string, keyHashes [][]byte) ([][]byte, error) {
+	mds := make([][]byte, len(keyHashes))
+	for i, kh := range keyHashes {
+		if vv := f.hashed[ns+coll+string(kh)]; vv != nil {
+			mds[i] = vv.Metadata
+		}
+	}
+	return mds, nil
+}
+
+// TestFinalizeTxOpsBlockReplayPreservesNilVsEmptyAcrossPublicAndHashedPaths replays a block
+// of two transactions -- the first committing a nil-valued write and an empty-byte-valued
+// write, the second updating only those same keys' metadata -- against both the public
+// (coll == "") and hashed private-data (coll != "") paths, and asserts finalizeTxOps merges
+// in the committed value for tx2 without collapsing the nil/[]byte{} distinction. See
+// FAB-18386 and the value field's doc comment on keyOps.
+func TestFinalizeTxOpsBlockReplayPreservesNilVsEmptyAcrossPublicAndHashedPaths(t *testing.T) {
+	for _, coll := range []string{"", "coll1"} {
+		db := newFakeDB()
+		precedingUpdates := newPubAndHashUpdates()
+
+		tx1 := txOps{}
+		tx1.upsert(compositeKey{ns: "ns1", coll: coll, key: "nilKey"}, nil)
+		tx1.upsert(compositeKey{ns: "ns1", coll: coll, key: "emptyKey"}, []byte{})
+		require.NoError(t, finalizeTxOps(tx1, precedingUpdates, db, nil))
+
+		for ck, keyop := range tx1 {
+			vv := &statedb.VersionedValue{Value: keyop.value, Metadata: keyop.metadata}
+			if coll == "" {
+				precedingUpdates.publicUpdates.Put(ck.ns, ck.key, vv)
+			} else {
+				precedingUpdates.hashUpdates.Put(ck.ns, ck.coll, ck.key, vv)
+			}
+		}
+
+		tx2 := txOps{}
+		tx2.metadataUpdate(compositeKey{ns: "ns1", coll: coll, key: "nilKey"}, []byte("md"))
+		tx2.metadataUpdate(compositeKey{ns: "ns1", coll: coll, key: "emptyKey"}, []byte("md"))
+		require.NoError(t, finalizeTxOps(tx2, precedingUpdates, db, nil))
+
+		assert.Nil(t, tx2[compositeKey{ns: "ns1", coll: coll, key: "nilKey"}].value, "coll=%q", coll)
+		assert.NotNil(t, tx2[compositeKey{ns: "ns1", coll: coll, key: "emptyKey"}].value, "coll=%q", coll)
+		assert.Len(t, tx2[compositeKey{ns: "ns1", coll: coll, key: "emptyKey"}].value, 0, "coll=%q", coll)
+	}
+}
+
+func TestExtractProvenanceDepsStructured(t *testing.T) {
+	kvRwSet := &kvrwset.KVRWSet{}
+	rwsetutil.EncodeProvenanceEntry(kvRwSet, &rwsetutil.ProvenanceEntry{Key: "k1", DepKeys: []string{"a", "b"}})
+	rwsetutil.EncodeProvenanceEntry(kvRwSet, &rwsetutil.ProvenanceEntry{Key: "k2", DepKeys: []string{}})
+
+	nsRWSet := &rwsetutil.NsRwSet{NameSpace: "ns1", KvRwSet: kvRwSet}
+	deps, err := extractProvenanceDeps("ns1", nsRWSet)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"k1": {"a", "b"}, "k2": {}}, deps)
+}
+
+// TestProvenanceEntryRoundTripsThroughKvRwSet asserts EncodeProvenanceEntry/
+// DecodeProvenanceRwSet survive exactly the kind of round trip a real transaction does:
+// attach at simulation time, read back after the rwset has (conceptually) crossed the
+// endorser->orderer->committer boundary as part of the namespace's own KvRwSet.
+func TestProvenanceEntryRoundTripsThroughKvRwSet(t *testing.T) {
+	kvRwSet := &kvrwset.KVRWSet{
+		Writes: []*kvrwset.KVWrite{{Key: "w1", Value: []byte("v1")}},
+	}
+	rwsetutil.EncodeProvenanceEntry(kvRwSet, &rwsetutil.ProvenanceEntry{
+		Key: "w1", DepKeys: []string{"a", "b"}, SnapshotBlk: 42,
+	})
+
+	provenanceRwSet := rwsetutil.DecodeProvenanceRwSet(kvRwSet)
+	require.NotNil(t, provenanceRwSet)
+	require.Len(t, provenanceRwSet.Entries, 1)
+	assert.Equal(t, "w1", provenanceRwSet.Entries[0].Key)
+	assert.Equal(t, []string{"a", "b"}, provenanceRwSet.Entries[0].DepKeys)
+	assert.Equal(t, uint64(42), provenanceRwSet.Entries[0].SnapshotBlk)
+}
+
+func TestExtractProvenanceDepsRejectsForgedSuffixWhenStructuredFormatActive(t *testing.T) {
+	// Once a namespace's rwset carries a ProvenanceRwSet, the new format is in effect and
+	// a user write landing on the reserved "_prov" suffix can only be a forgery attempting
+	// to impersonate a lineage dependency record -- it must never fall through to an
+	// ordinary committed write, regardless of localconfig.LegacyProvenanceFormat().
+	kvRwSet := &kvrwset.KVRWSet{
+		Writes: []*kvrwset.KVWrite{
+			{Key: "attacker_prov", Value: []byte("forged")},
+		},
+	}
+	rwsetutil.EncodeProvenanceEntry(kvRwSet, &rwsetutil.ProvenanceEntry{Key: "legit", DepKeys: []string{"a"}})
+	nsRWSet := &rwsetutil.NsRwSet{NameSpace: "ns1", KvRwSet: kvRwSet}
+	_, err := extractProvenanceDeps("ns1", nsRWSet)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "reserved")
+}
+
+func TestExtractProvenanceDepsLegacyNilValueIsNotAnEmptyDep(t *testing.T) {
+	defer localconfig.SetLegacyProvenanceFormat(localconfig.LegacyProvenanceFormat())
+	localconfig.SetLegacyProvenanceFormat(true)
+
+	nsRWSet := &rwsetutil.NsRwSet{
+		KvRwSet: &kvrwset.KVRWSet{
+			Writes: []*kvrwset.KVWrite{
+				{Key: "k1_prov", Value: nil},
+				{Key: "k2_prov", Value: []byte("a_b")},
+				{Key: "k1", Value: []byte("v1")},
+				{Key: "k2", Value: []byte("v2")},
+			},
+		},
+	}
+	deps, err := extractProvenanceDeps("ns1", nsRWSet)
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, deps["k1"])
+	assert.Equal(t, []string{"a", "b"}, deps["k2"])
+}
+
+func TestExtractProvenanceDepsRejectsReservedSuffixOnceLegacyDisabled(t *testing.T) {
+	defer localconfig.SetLegacyProvenanceFormat(localconfig.LegacyProvenanceFormat())
+	localconfig.SetLegacyProvenanceFormat(false)
+
+	nsRWSet := &rwsetutil.NsRwSet{
+		KvRwSet: &kvrwset.KVRWSet{
+			Writes: []*kvrwset.KVWrite{
+				{Key: "attacker_prov", Value: []byte("forged")},
+			},
+		},
+	}
+	_, err := extractProvenanceDeps("ns1", nsRWSet)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "reserved")
+}
+
+func TestDepSnapshotForWriteTakesMaxOfMatchedDeps(t *testing.T) {
+	readBlockNums := map[string]uint64{
+		"a": 3,
+		"b": 7,
+		"c": 2,
+	}
+	assert.Equal(t, uint64(7), depSnapshotForWrite([]string{"a", "b"}, readBlockNums))
+	assert.Equal(t, uint64(3), depSnapshotForWrite([]string{"a", "c"}, readBlockNums))
+	assert.Equal(t, uint64(2), depSnapshotForWrite([]string{"c"}, readBlockNums))
+}
+
+// TestDepSnapshotForWriteIgnoresUnversionedDepInMax asserts a dep key read with no prior
+// version (readBlockNums holds math.MaxUint64 for it, meaning "this key didn't exist yet")
+// never wins a max() against a dep key genuinely read at a real committed block -- it must
+// be excluded from the max, not folded into it as if it were the write's binding
+// constraint, or the write loses its OCC snapshot protection entirely.
+func TestDepSnapshotForWriteIgnoresUnversionedDepInMax(t *testing.T) {
+	readBlockNums := map[string]uint64{
+		"a": 5,
+		"b": math.MaxUint64,
+	}
+	assert.Equal(t, uint64(5), depSnapshotForWrite([]string{"a", "b"}, readBlockNums))
+	// every matched dep is unversioned: no real constraint exists at all
+	assert.Equal(t, uint64(math.MaxUint64), depSnapshotForWrite([]string{"b"}, readBlockNums))
+}
+
+func TestDepSnapshotForWriteFallsBackToMaxUint64(t *testing.T) {
+	readBlockNums := map[string]uint64{"a": 3}
+	// no deps at all
+	assert.Equal(t, uint64(math.MaxUint64), depSnapshotForWrite(nil, readBlockNums))
+	// deps present but none of them were read this tx
+	assert.Equal(t, uint64(math.MaxUint64), depSnapshotForWrite([]string{"z"}, readBlockNums))
+	// OCC disabled: no read-block-number map at all
+	assert.Equal(t, uint64(math.MaxUint64), depSnapshotForWrite([]string{"a"}, nil))
+}
+
+func TestApplyTxRwsetPerWriteDepSnapshotUnderOCC(t *testing.T) {
+	defer localconfig.SetLegacyProvenanceFormat(localconfig.LegacyProvenanceFormat())
+	defer localconfig.SetLineageSupported(localconfig.LineageSupported())
+	defer localconfig.SetOCC(localconfig.IsOCC())
+	localconfig.SetLegacyProvenanceFormat(false)
+	localconfig.SetLineageSupported(true)
+	localconfig.SetOCC(true)
+
+	kvRwSet := &kvrwset.KVRWSet{
+		Reads: []*kvrwset.KVRead{
+			{Key: "a", Version: &kvrwset.Version{BlockNum: 3}},
+			{Key: "b", Version: &kvrwset.Version{BlockNum: 9}},
+			{Key: "c", Version: nil}, // c did not exist yet when read
+		},
+		Writes: []*kvrwset.KVWrite{
+			{Key: "w1", Value: []byte("v1")},
+			{Key: "w2", Value: []byte("v2")},
+			{Key: "w3", Value: []byte("v3")},
+		},
+	}
+	rwsetutil.EncodeProvenanceEntry(kvRwSet, &rwsetutil.ProvenanceEntry{Key: "w1", DepKeys: []string{"a"}})
+	rwsetutil.EncodeProvenanceEntry(kvRwSet, &rwsetutil.ProvenanceEntry{Key: "w2", DepKeys: []string{"a", "b"}})
+	// w3 depends on both a real, versioned read (a, block 3) and an unversioned one (c,
+	// never written) -- the unversioned dep must not swallow the real constraint.
+	rwsetutil.EncodeProvenanceEntry(kvRwSet, &rwsetutil.ProvenanceEntry{Key: "w3", DepKeys: []string{"a", "c"}})
+
+	rwset := &rwsetutil.TxRwSet{
+		NsRwSets: []*rwsetutil.NsRwSet{
+			{
+				NameSpace: "ns1",
+				KvRwSet:   kvRwSet,
+			},
+		},
+	}
+
+	txops := txOps{}
+	require.NoError(t, txops.applyTxRwset(rwset))
+
+	assert.Equal(t, uint64(3), txops[compositeKey{ns: "ns1", key: "w1"}].depSnapshot)
+	assert.Equal(t, uint64(9), txops[compositeKey{ns: "ns1", key: "w2"}].depSnapshot)
+	assert.Equal(t, uint64(3), txops[compositeKey{ns: "ns1", key: "w3"}].depSnapshot)
+}
+
+// TestApplyTxRwsetDoesNotLeakProvenanceIntoCommittedMetadata asserts the reserved-name
+// KVMetadataEntry EncodeProvenanceEntry folds onto a write's own KVMetadataWrite (see
+// provenanceMetadataEntryName) never reaches that key's committed statedb metadata --
+// applyTxRwset must strip it before applying, or it would reopen the exact "_prov keys get
+// committed to the ledger like real writes" hole ProvenanceRwSet exists to close.
+func TestApplyTxRwsetDoesNotLeakProvenanceIntoCommittedMetadata(t *testing.T) {
+	defer localconfig.SetLineageSupported(localconfig.LineageSupported())
+	localconfig.SetLineageSupported(true)
+
+	kvRwSet := &kvrwset.KVRWSet{
+		Writes: []*kvrwset.KVWrite{{Key: "w1", Value: []byte("v1")}},
+	}
+	rwsetutil.EncodeProvenanceEntry(kvRwSet, &rwsetutil.ProvenanceEntry{Key: "w1", DepKeys: []string{"a"}})
+
+	rwset := &rwsetutil.TxRwSet{
+		NsRwSets: []*rwsetutil.NsRwSet{{NameSpace: "ns1", KvRwSet: kvRwSet}},
+	}
+
+	txops := txOps{}
+	require.NoError(t, txops.applyTxRwset(rwset))
+
+	keyops, ok := txops[compositeKey{ns: "ns1", key: "w1"}]
+	require.True(t, ok)
+	assert.False(t, keyops.flag&metadataUpdate == metadataUpdate,
+		"applyTxRwset must not turn a write's own provenance entry into a committed metadata update")
+	assert.Nil(t, keyops.metadata)
+}
+
+// TestApplyTxRwsetStripsProvenanceButKeepsRealMetadataOnSameWrite asserts a write that
+// legitimately carries both a provenance entry and an unrelated real metadata entry still
+// gets the real entry committed -- stripping provenance must not collaterally drop it.
+func TestApplyTxRwsetStripsProvenanceButKeepsRealMetadataOnSameWrite(t *testing.T) {
+	defer localconfig.SetLineageSupported(localconfig.LineageSupported())
+	localconfig.SetLineageSupported(true)
+
+	kvRwSet := &kvrwset.KVRWSet{
+		Writes: []*kvrwset.KVWrite{{Key: "w1", Value: []byte("v1")}},
+		MetadataWrites: []*kvrwset.KVMetadataWrite{
+			{Key: "w1", Entries: []*kvrwset.KVMetadataEntry{{Name: "real-entry", Value: []byte("real")}}},
+		},
+	}
+	rwsetutil.EncodeProvenanceEntry(kvRwSet, &rwsetutil.ProvenanceEntry{Key: "w1", DepKeys: []string{"a"}})
+
+	rwset := &rwsetutil.TxRwSet{
+		NsRwSets: []*rwsetutil.NsRwSet{{NameSpace: "ns1", KvRwSet: kvRwSet}},
+	}
+
+	txops := txOps{}
+	require.NoError(t, txops.applyTxRwset(rwset))
+
+	keyops, ok := txops[compositeKey{ns: "ns1", key: "w1"}]
+	require.True(t, ok)
+	require.True(t, keyops.flag&metadataUpdate == metadataUpdate)
+	require.NotNil(t, keyops.metadata)
+	assert.NotContains(t, string(keyops.metadata), "_fabricsharp_lineage_deps")
+}
+
+func TestCollectBlockOpsKeysDedupsAcrossTransactions(t *testing.T) {
+	tx1 := txOps{}
+	tx1.upsert(compositeKey{ns: "ns1", key: "k1"}, []byte("v1"))         // needs metadata (isOnlyUpsert)
+	tx1.metadataUpdate(compositeKey{ns: "ns1", key: "k2"}, []byte("md")) // needs value
+
+	tx2 := txOps{}
+	tx2.upsert(compositeKey{ns: "ns1", key: "k1"}, []byte("v1-again")) // same key, different tx
+	tx2.delete(compositeKey{ns: "ns1", key: "k3"})                     // deletes never need a lookup
+
+	valueKeys, metadataKeys := collectBlockOpsKeys([]txOps{tx1, tx2})
+
+	nc := NsColl{Namespace: "ns1"}
+	require.Contains(t, metadataKeys, nc)
+	assert.Equal(t, map[string]bool{"k1": true}, metadataKeys[nc])
+	require.Contains(t, valueKeys, nc)
+	assert.Equal(t, map[string]bool{"k2": true}, valueKeys[nc])
+}
+
+// TestPreloadBlockOpsMergesExtraKeysWithBlocksOwnKeys asserts a caller combining its own
+// bulk lookup (e.g. VSCC's endorsement-policy key resolution) with this block's preload via
+// PreloadKeys gets both sets of keys resolved in the very same pass.
+func TestPreloadBlockOpsMergesExtraKeysWithBlocksOwnKeys(t *testing.T) {
+	db := newFakeDB()
+	f := db.VersionedDB.(*fakeDB)
+	f.public["ns1block-owned"] = &statedb.VersionedValue{Value: []byte("v1")}
+	f.public["ns1vscc-owned"] = &statedb.VersionedValue{Value: []byte("v2")}
+
+	tx1 := txOps{}
+	tx1.metadataUpdate(compositeKey{ns: "ns1", key: "block-owned"}, []byte("md"))
+	allTxOps := []txOps{tx1}
+
+	extra := &PreloadKeys{
+		ValueKeys: map[NsColl]map[string]bool{
+			{Namespace: "ns1"}: {"vscc-owned": true},
+		},
+	}
+
+	cache, err := preloadBlockOps(allTxOps, db, extra)
+	require.NoError(t, err)
+
+	require.Contains(t, cache.values, compositeKey{ns: "ns1", key: "vscc-owned"})
+	assert.Equal(t, []byte("v2"), cache.values[compositeKey{ns: "ns1", key: "vscc-owned"}].Value)
+	require.Contains(t, cache.metadata, compositeKey{ns: "ns1", key: "block-owned"})
+}
+
+// roundTripLatencyDB wraps a statedb.VersionedDB with a fixed artificial delay on every
+// call, standing in for the network/storage round-trip cost a real backend like CouchDB
+// pays per GetState*/GetStateMetadata* call regardless of how many keys that one call
+// resolves -- the cost prepareBlockOps's bulk preload exists to pay once per block instead
+// of once per transaction. It is deliberately crude (a plain time.Sleep, not a simulated
+// CouchDB), but it is enough to make the two preload strategies' call-count difference show
+// up as wall-clock time, which an unadorned in-memory fake db (zero per-call cost either
+// way) cannot.
+type roundTripLatencyDB struct {
+	statedb.VersionedDB
+	delay time.Duration
+}
+
+func (d roundTripLatencyDB) GetState(ns, key string) (*statedb.VersionedValue, error) {
+	time.Sleep(d.delay)
+	return d.VersionedDB.GetState(ns, key)
+}
+
+func (d roundTripLatencyDB) GetStateMetadata(ns, key string) ([]byte, error) {
+	time.Sleep(d.delay)
+	return d.VersionedDB.GetStateMetadata(ns, key)
+}
+
+func (d roundTripLatencyDB) GetStateMultipleKeys(ns string, keys []string) ([]*statedb.VersionedValue, error) {
+	time.Sleep(d.delay)
+	return d.VersionedDB.GetStateMultipleKeys(ns, keys)
+}
+
+func (d roundTripLatencyDB) GetStateMetadataMultipleKeys(ns string, keys []string) ([][]byte, error) {
+	time.Sleep(d.delay)
+	return d.VersionedDB.GetStateMetadataMultipleKeys(ns, keys)
+}
+
+// BenchmarkBulkPreloadVsPerTxLookup compares prepareBlockOps's block-wide bulk preload
+// against the one-key-at-a-time lookups prepareTxOps/retrieveLatestState would otherwise
+// repeat per transaction -- the win FAB-11328 called out and bulk preloading exists to
+// capture. Every transaction in the simulated block needs the same keyspace's latest value
+// (an upsert missing its metadata) or metadata (a metadata update missing its value), so the
+// per-tx path pays one simulated db round trip per key per transaction, while the bulk path
+// pays exactly one round trip per namespace for the whole block.
+func BenchmarkBulkPreloadVsPerTxLookup(b *testing.B) {
+	const numTx = 200
+	const keysPerTx = 10
+	const simulatedRoundTrip = 200 * time.Microsecond
+
+	newBlock := func() []txOps {
+		allTxOps := make([]txOps, numTx)
+		for i := 0; i < numTx; i++ {
+			txops := txOps{}
+			for k := 0; k < keysPerTx; k++ {
+				key := fmt.Sprintf("k%d", k) // every tx in the block touches the same keyspace
+				if k%2 == 0 {
+					txops.upsert(compositeKey{ns: "ns1", key: key}, []byte("v"))
+				} else {
+					txops.metadataUpdate(compositeKey{ns: "ns1", key: key}, []byte("md"))
+				}
+			}
+			allTxOps[i] = txops
+		}
+		return allTxOps
+	}
+
+	newLatencyDB := func() *privacyenabledstate.DB {
+		db := newFakeDB()
+		db.VersionedDB = roundTripLatencyDB{VersionedDB: db.VersionedDB, delay: simulatedRoundTrip}
+		return db
+	}
+
+	b.Run("BulkPreload", func(b *testing.B) {
+		db := newLatencyDB()
+		for i := 0; i < b.N; i++ {
+			allTxOps := newBlock()
+			cache, err := preloadBlockOps(allTxOps, db, nil)
+			require.NoError(b, err)
+			for _, txops := range allTxOps {
+				require.NoError(b, finalizeTxOps(txops, newPubAndHashUpdates(), db, cache))
+			}
+		}
+	})
+
+	b.Run("PerTxLookup", func(b *testing.B) {
+		db := newLatencyDB()
+		for i := 0; i < b.N; i++ {
+			allTxOps := newBlock()
+			for _, txops := range allTxOps {
+				require.NoError(b, finalizeTxOps(txops, newPubAndHashUpdates(), db, nil))
+			}
+		}
+	})
+}
+
+func TestKeyOpsPreservesNilValue(t *testing.T) {
+	txops := txOps{}
+	k := compositeKey{ns: "ns1", key: "k1"}
+	txops.upsert(k, nil)
+	assert.True(t, txops[k].isOnlyUpsert())
+	assert.False(t, txops[k].isDelete())
+	assert.Nil(t, txops[k].value)
+
+	txops.upsert(k, []byte{})
+	assert.NotNil(t, txops[k].value)
+	assert.Len(t, txops[k].value, 0)
+}
@@ -0,0 +1,53 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package validation
+
+import "github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+
+// publicAndHashUpdates accumulates, across a block's already-validated transactions, the
+// public and hashed private-data writes that a later transaction in the same block must see
+// ahead of whatever is currently committed in statedb. retrieveLatestState and
+// retrieveLatestMetadata consult it before ever falling through to db or the block-level
+// cache preloaded by preloadBlockOps.
+type publicAndHashUpdates struct {
+	publicUpdates *pubUpdateBatch
+	hashUpdates   *hashUpdateBatch
+}
+
+func newPubAndHashUpdates() *publicAndHashUpdates {
+	return &publicAndHashUpdates{
+		publicUpdates: &pubUpdateBatch{entries: map[compositeKey]*statedb.VersionedValue{}},
+		hashUpdates:   &hashUpdateBatch{entries: map[compositeKey]*statedb.VersionedValue{}},
+	}
+}
+
+// pubUpdateBatch holds the pending public-state writes of transactions already merged
+// earlier in the block currently being validated.
+type pubUpdateBatch struct {
+	entries map[compositeKey]*statedb.VersionedValue
+}
+
+func (b *pubUpdateBatch) Get(ns, key string) *statedb.VersionedValue {
+	return b.entries[compositeKey{ns: ns, key: key}]
+}
+
+func (b *pubUpdateBatch) Put(ns, key string, vv *statedb.VersionedValue) {
+	b.entries[compositeKey{ns: ns, key: key}] = vv
+}
+
+// hashUpdateBatch mirrors pubUpdateBatch for hashed private-data collections.
+type hashUpdateBatch struct {
+	entries map[compositeKey]*statedb.VersionedValue
+}
+
+func (b *hashUpdateBatch) Get(ns, coll, key string) *statedb.VersionedValue {
+	return b.entries[compositeKey{ns: ns, coll: coll, key: key}]
+}
+
+func (b *hashUpdateBatch) Put(ns, coll, key string, vv *statedb.VersionedValue) {
+	b.entries[compositeKey{ns: ns, coll: coll, key: key}] = vv
+}
@@ -0,0 +1,57 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package localconfig
+
+// These package-level flags gate the lineage/OCC-aware validation path in
+// core/ledger/kvledger/txmgmt/validation. On a real peer they are derived from the node's
+// local core.yaml configuration at startup; the setters exist so tests (and, for
+// LegacyProvenanceFormat, ledgers upgrading across the ProvenanceRwSet cutover) can flip
+// them without a config file on disk.
+var (
+	lineageSupported       bool
+	occEnabled             bool
+	legacyProvenanceFormat bool
+)
+
+// LineageSupported reports whether this peer validates chaincode writes with lineage
+// dependency tracking enabled.
+func LineageSupported() bool {
+	return lineageSupported
+}
+
+// SetLineageSupported is exposed for tests that need to flip lineage support without a
+// config file.
+func SetLineageSupported(supported bool) {
+	lineageSupported = supported
+}
+
+// IsOCC reports whether this peer validates lineage-tracked writes under optimistic
+// concurrency control (deriving each write's dependency snapshot from its own read set)
+// rather than plain MVCC.
+func IsOCC() bool {
+	return occEnabled
+}
+
+// SetOCC is exposed for tests that need to flip OCC without a config file.
+func SetOCC(occ bool) {
+	occEnabled = occ
+}
+
+// LegacyProvenanceFormat reports whether extractProvenanceDeps should still accept the
+// legacy "<key>_prov" -> "dep1_dep2_..." write convention for rwsets produced before
+// ProvenanceRwSet existed. It defaults to false: once a namespace's rwset carries no
+// ProvenanceRwSet and this flag is off, a write landing on the reserved "_prov" suffix is
+// rejected rather than silently reinterpreted.
+func LegacyProvenanceFormat() bool {
+	return legacyProvenanceFormat
+}
+
+// SetLegacyProvenanceFormat is exposed for tests, and for peers upgrading a ledger that
+// still has un-migrated "_prov" writes in flight.
+func SetLegacyProvenanceFormat(legacy bool) {
+	legacyProvenanceFormat = legacy
+}
@@ -0,0 +1,31 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package lifecycle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChaincodeConcurrencyModeString(t *testing.T) {
+	assert.Equal(t, "MVCC", ChaincodeConcurrencyMode_MVCC.String())
+	assert.Equal(t, "OCC", ChaincodeConcurrencyMode_OCC.String())
+	assert.Equal(t, "SNAPSHOT", ChaincodeConcurrencyMode_SNAPSHOT.String())
+	assert.Equal(t, "3", ChaincodeConcurrencyMode(3).String(), "unrecognized values fall back to their numeric form")
+}
+
+func TestQueryChaincodeConcurrencyModeResultDefaultsToMVCC(t *testing.T) {
+	result := &QueryChaincodeConcurrencyModeResult{}
+	assert.Equal(t, ChaincodeConcurrencyMode_MVCC, result.ConcurrencyMode,
+		"the zero value must mean MVCC so a definition predating this field keeps behaving as one")
+	assert.Equal(t, uint64(0), result.SnapshotBlockNum)
+
+	result = &QueryChaincodeConcurrencyModeResult{ConcurrencyMode: ChaincodeConcurrencyMode_OCC, SnapshotBlockNum: 42}
+	assert.Equal(t, ChaincodeConcurrencyMode_OCC, result.ConcurrencyMode)
+	assert.Equal(t, uint64(42), result.SnapshotBlockNum)
+}